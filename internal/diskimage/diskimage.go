@@ -0,0 +1,143 @@
+// Package diskimage creates disk images and manages their attachment to a
+// domain via qemu-img and virsh.
+package diskimage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Disk describes one entry from `virsh domblklist --details`.
+type Disk struct {
+	Target string
+	Source string
+}
+
+// List enumerates every disk attached to vmName.
+func List(vmName string) ([]Disk, error) {
+	out, err := exec.Command("virsh", "domblklist", vmName, "--details").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("virsh domblklist failed: %w: %s", err, out)
+	}
+	return parseDomblklist(string(out)), nil
+}
+
+// parseDomblklist parses the table printed by `virsh domblklist --details`,
+// skipping its header and separator rows.
+func parseDomblklist(output string) []Disk {
+	var disks []Disk
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			continue
+		}
+		if fields[0] == "Type" || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		disks = append(disks, Disk{Target: fields[2], Source: fields[3]})
+	}
+	return disks
+}
+
+// Size returns the human-readable virtual size reported by `qemu-img info`
+// for the disk image at path: the rest of the "virtual size:" line, e.g.
+// "20 GiB (21474836480 bytes)".
+func Size(path string) (string, error) {
+	out, err := exec.Command("qemu-img", "info", path).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("qemu-img info failed: %w: %s", err, out)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if after, ok := strings.CutPrefix(line, "virtual size:"); ok {
+			return strings.TrimSpace(after), nil
+		}
+	}
+	return "", fmt.Errorf("could not find \"virtual size\" in qemu-img info output")
+}
+
+// Format returns the on-disk format reported by `qemu-img info` for the
+// image at path, e.g. "qcow2" or "raw".
+func Format(path string) (string, error) {
+	out, err := exec.Command("qemu-img", "info", path).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("qemu-img info failed: %w: %s", err, out)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if after, ok := strings.CutPrefix(line, "file format:"); ok {
+			return strings.TrimSpace(after), nil
+		}
+	}
+	return "", fmt.Errorf("could not find \"file format\" in qemu-img info output")
+}
+
+// Create provisions a new disk image at path via `qemu-img create`.
+func Create(path, format, size, backingFile string) error {
+	args := []string{"create", "-f", format}
+	if backingFile != "" {
+		args = append(args, "-b", backingFile, "-F", format)
+	}
+	args = append(args, path, size)
+
+	out, err := exec.Command("qemu-img", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("qemu-img create failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// Attach wires path into vmName as target via `virsh attach-device`. When
+// live is true the change is also applied to the running domain.
+func Attach(vmName, target, path, format string, live bool) error {
+	xml := fmt.Sprintf(`<disk type='file' device='disk'>
+  <driver name='qemu' type='%s'/>
+  <source file='%s'/>
+  <target dev='%s' bus='virtio'/>
+</disk>
+`, format, path, target)
+
+	xmlFile, err := os.CreateTemp("", "kvm-vm-tune-disk-*.xml")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary disk XML: %w", err)
+	}
+	defer os.Remove(xmlFile.Name())
+
+	if _, err := xmlFile.WriteString(xml); err != nil {
+		xmlFile.Close()
+		return fmt.Errorf("failed to write temporary disk XML: %w", err)
+	}
+	if err := xmlFile.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary disk XML: %w", err)
+	}
+
+	args := []string{"attach-device", vmName, xmlFile.Name(), "--config"}
+	if live {
+		args = append(args, "--live")
+	}
+
+	out, err := exec.Command("virsh", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh attach-device failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// Detach removes target from vmName via `virsh detach-disk`. When live is
+// true the change is also applied to the running domain.
+func Detach(vmName, target string, live bool) error {
+	args := []string{"detach-disk", vmName, target, "--config"}
+	if live {
+		args = append(args, "--live")
+	}
+
+	out, err := exec.Command("virsh", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh detach-disk failed: %w: %s", err, out)
+	}
+	return nil
+}
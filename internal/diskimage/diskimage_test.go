@@ -0,0 +1,55 @@
+package diskimage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDomblklist(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Disk
+	}{
+		{
+			name: "single disk",
+			output: "Type       Device     Target     Source\n" +
+				"------------------------------------------------\n" +
+				"file       disk       vda        /var/lib/libvirt/images/vm1.qcow2\n",
+			want: []Disk{
+				{Target: "vda", Source: "/var/lib/libvirt/images/vm1.qcow2"},
+			},
+		},
+		{
+			name: "multiple disks",
+			output: "Type       Device     Target     Source\n" +
+				"------------------------------------------------\n" +
+				"file       disk       vda        /var/lib/libvirt/images/vm1.qcow2\n" +
+				"file       disk       vdb        /var/lib/libvirt/images/vm1-data.qcow2\n",
+			want: []Disk{
+				{Target: "vda", Source: "/var/lib/libvirt/images/vm1.qcow2"},
+				{Target: "vdb", Source: "/var/lib/libvirt/images/vm1-data.qcow2"},
+			},
+		},
+		{
+			name: "no disks",
+			output: "Type       Device     Target     Source\n" +
+				"------------------------------------------------\n",
+			want: nil,
+		},
+		{
+			name:   "empty output",
+			output: "",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDomblklist(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDomblklist(%q) = %#v, want %#v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Package live drives the virsh/qemu-agent commands needed to change CPU,
+// memory, and disk resources on a running domain without stopping it.
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	guestExecPollAttempts = 30
+	guestExecPollInterval = 2 * time.Second
+)
+
+// SetVCPUs hot-plugs the vCPU count for a running domain via
+// `virsh setvcpus --live`. It refuses to request more than the domain's
+// configured maximum, since growing that maximum requires an offline change.
+func SetVCPUs(vmName string, cpuCount int) error {
+	maxVCPUs, err := maxVCPUs(vmName)
+	if err != nil {
+		return fmt.Errorf("failed to determine max vCPU count for VM '%s': %w", vmName, err)
+	}
+	if cpuCount > maxVCPUs {
+		return fmt.Errorf("requested vCPU count %d exceeds VM '%s' maximum of %d; increase the maximum offline first", cpuCount, vmName, maxVCPUs)
+	}
+
+	out, err := exec.Command("virsh", "setvcpus", vmName, strconv.Itoa(cpuCount), "--live").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh setvcpus --live failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+func maxVCPUs(vmName string) (int, error) {
+	out, err := exec.Command("virsh", "dommaxvcpus", vmName).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("virsh dommaxvcpus failed: %w: %s", err, out)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected output from virsh dommaxvcpus: %q", out)
+	}
+	return max, nil
+}
+
+// SetMemory hot-plugs the balloon target for a running domain via
+// `virsh setmem --live`. memoryKiB is the new size in KiB, the unit virsh
+// setmem expects.
+func SetMemory(vmName string, memoryKiB int) error {
+	maxMemoryKiB, err := maxMemory(vmName)
+	if err != nil {
+		return fmt.Errorf("failed to determine max memory for VM '%s': %w", vmName, err)
+	}
+	if memoryKiB > maxMemoryKiB {
+		return fmt.Errorf("requested memory %dKiB exceeds VM '%s' maximum of %dKiB; increase the maximum offline first", memoryKiB, vmName, maxMemoryKiB)
+	}
+
+	out, err := exec.Command("virsh", "setmem", vmName, strconv.Itoa(memoryKiB), "--live").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh setmem --live failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+func maxMemory(vmName string) (int, error) {
+	out, err := exec.Command("virsh", "dominfo", vmName).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("virsh dominfo failed: %w: %s", err, out)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "Max memory:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			break
+		}
+		return strconv.Atoi(fields[2])
+	}
+	return 0, fmt.Errorf("could not find \"Max memory\" in virsh dominfo output")
+}
+
+// ParseMemorySize converts a human size such as "4G" or "512M" into KiB, the
+// unit virsh setmem expects.
+func ParseMemorySize(size string) (int, error) {
+	if len(size) < 2 {
+		return 0, fmt.Errorf("invalid memory size %q", size)
+	}
+
+	value, err := strconv.Atoi(size[:len(size)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %q: %w", size, err)
+	}
+
+	switch size[len(size)-1] {
+	case 'K', 'k':
+		return value, nil
+	case 'M', 'm':
+		return value * 1024, nil
+	case 'G', 'g':
+		return value * 1024 * 1024, nil
+	case 'T', 't':
+		return value * 1024 * 1024 * 1024, nil
+	default:
+		return 0, fmt.Errorf("invalid memory size %q: unit must be one of K, M, G, T", size)
+	}
+}
+
+// ResizeDisk grows a disk attached to a running domain via
+// `virsh blockresize`.
+func ResizeDisk(vmName, device, size string) error {
+	out, err := exec.Command("virsh", "blockresize", vmName, device, size).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh blockresize failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// GrowGuestFilesystem asks the guest agent to grow the partition and
+// filesystem on guestDevice (a guest-visible path such as "/dev/vda"),
+// using growpart followed by resize2fs (falling back to xfs_growfs for XFS
+// filesystems).
+func GrowGuestFilesystem(vmName, guestDevice string, partition int) error {
+	if err := guestExec(vmName, "growpart", guestDevice, strconv.Itoa(partition)); err != nil {
+		return fmt.Errorf("guest agent unreachable or growpart failed for VM '%s': %w", vmName, err)
+	}
+
+	partitionDevice := fmt.Sprintf("%s%d", guestDevice, partition)
+	if err := guestExec(vmName, "resize2fs", partitionDevice); err != nil {
+		if err := guestExec(vmName, "xfs_growfs", partitionDevice); err != nil {
+			return fmt.Errorf("guest agent unreachable or filesystem resize failed for VM '%s': %w", vmName, err)
+		}
+	}
+	return nil
+}
+
+// guestExec runs path inside vmName via the guest agent and blocks until it
+// reports the process as exited, returning an error if it exited non-zero or
+// did not exit within guestExecPollAttempts polls.
+func guestExec(vmName, path string, args ...string) error {
+	pid, err := guestExecStart(vmName, path, args...)
+	if err != nil {
+		return err
+	}
+	return guestExecWait(vmName, pid)
+}
+
+func guestExecStart(vmName, path string, args ...string) (int, error) {
+	argList := make([]string, len(args))
+	for i, a := range args {
+		argList[i] = fmt.Sprintf("%q", a)
+	}
+	cmd := fmt.Sprintf(`{"execute":"guest-exec","arguments":{"path":"%s","arg":[%s],"capture-output":true}}`, path, strings.Join(argList, ","))
+
+	out, err := exec.Command("virsh", "qemu-agent-command", vmName, cmd).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", err, out)
+	}
+
+	var reply struct {
+		Return struct {
+			PID int `json:"pid"`
+		} `json:"return"`
+	}
+	if err := json.Unmarshal(out, &reply); err != nil {
+		return 0, fmt.Errorf("unexpected guest-exec reply: %w: %s", err, out)
+	}
+	return reply.Return.PID, nil
+}
+
+// guestExecWait polls guest-exec-status until the guest-exec'd process
+// reports as exited, since guest-exec itself only confirms the agent
+// accepted the request.
+func guestExecWait(vmName string, pid int) error {
+	statusCmd := fmt.Sprintf(`{"execute":"guest-exec-status","arguments":{"pid":%d}}`, pid)
+
+	for attempt := 0; attempt < guestExecPollAttempts; attempt++ {
+		out, err := exec.Command("virsh", "qemu-agent-command", vmName, statusCmd).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+
+		var status struct {
+			Return struct {
+				Exited   bool `json:"exited"`
+				ExitCode int  `json:"exitcode"`
+			} `json:"return"`
+		}
+		if err := json.Unmarshal(out, &status); err != nil {
+			return fmt.Errorf("unexpected guest-exec-status reply: %w: %s", err, out)
+		}
+
+		if status.Return.Exited {
+			if status.Return.ExitCode != 0 {
+				return fmt.Errorf("guest command exited with status %d", status.Return.ExitCode)
+			}
+			return nil
+		}
+
+		time.Sleep(guestExecPollInterval)
+	}
+
+	return fmt.Errorf("timed out waiting for guest command to finish")
+}
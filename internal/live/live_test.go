@@ -0,0 +1,34 @@
+package live
+
+import "testing"
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    string
+		want    int
+		wantErr bool
+	}{
+		{name: "kibibytes", size: "512K", want: 512},
+		{name: "mebibytes", size: "512M", want: 512 * 1024},
+		{name: "gibibytes", size: "4G", want: 4 * 1024 * 1024},
+		{name: "tebibytes", size: "1T", want: 1024 * 1024 * 1024},
+		{name: "lowercase unit", size: "4g", want: 4 * 1024 * 1024},
+		{name: "missing unit", size: "4", wantErr: true},
+		{name: "invalid unit", size: "4X", wantErr: true},
+		{name: "invalid number", size: "abcG", wantErr: true},
+		{name: "empty", size: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMemorySize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMemorySize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParseMemorySize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
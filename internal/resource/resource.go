@@ -0,0 +1,155 @@
+// Package resource holds the resource-mutation logic shared between the
+// individual cpu/memory/disk verbs and the aggregate set command.
+package resource
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zinrai/libvirtwrap-go/pkg/disk"
+	"github.com/zinrai/libvirtwrap-go/pkg/vm"
+
+	"github.com/zinrai/kvm-vm-tune/internal/diskimage"
+)
+
+// ApplyCPU sets the vCPU count for myVM.
+func ApplyCPU(myVM *vm.VM, cpuCount int) error {
+	if err := myVM.SetCPUCount(cpuCount); err != nil {
+		return fmt.Errorf("failed to change CPU count: %w", err)
+	}
+	return nil
+}
+
+// ApplyMemory sets the memory size for myVM.
+func ApplyMemory(myVM *vm.VM, memorySize string) error {
+	if err := myVM.SetMemorySize(memorySize); err != nil {
+		return fmt.Errorf("failed to change memory size: %w", err)
+	}
+	return nil
+}
+
+// ResolveDiskPath returns imagePath and target unchanged if imagePath is
+// set. Otherwise it resolves target to its backing path if given, falls
+// back to the VM's only disk if it has just one, prompts interactively when
+// stdout is a terminal and the VM has several, or else errors out listing
+// the available disks. When imagePath is empty, the returned target is the
+// libvirt device name backing the returned path, so callers that need to
+// address the disk on the host (e.g. for a live blockresize) don't have to
+// re-derive it; when imagePath is set, target is returned as given, which may
+// be empty.
+func ResolveDiskPath(vmName, imagePath, target string) (string, string, error) {
+	if imagePath != "" {
+		return imagePath, target, nil
+	}
+
+	disks, err := diskimage.List(vmName)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get disk information for VM '%s': %w", vmName, err)
+	}
+	if len(disks) == 0 {
+		return "", "", fmt.Errorf("no disks found for VM '%s'", vmName)
+	}
+
+	if target != "" {
+		for _, d := range disks {
+			if d.Target == target {
+				return d.Source, d.Target, nil
+			}
+		}
+		return "", "", fmt.Errorf("target device %q not found for VM '%s'; available devices: %s", target, vmName, diskTargets(disks))
+	}
+
+	if len(disks) == 1 {
+		return disks[0].Source, disks[0].Target, nil
+	}
+
+	if isInteractive() {
+		return pickDiskInteractively(disks)
+	}
+
+	return "", "", fmt.Errorf("VM '%s' has multiple disks; re-run with --image or --target to select one:\n%s", vmName, formatDiskList(disks))
+}
+
+func diskTargets(disks []diskimage.Disk) string {
+	targets := make([]string, len(disks))
+	for i, d := range disks {
+		targets[i] = d.Target
+	}
+	return strings.Join(targets, ", ")
+}
+
+func formatDiskList(disks []diskimage.Disk) string {
+	var b strings.Builder
+	for _, d := range disks {
+		fmt.Fprintf(&b, "  %s\t%s\t%s\n", d.Target, d.Source, diskSize(d.Source))
+	}
+	return b.String()
+}
+
+func pickDiskInteractively(disks []diskimage.Disk) (string, string, error) {
+	fmt.Fprintln(os.Stderr, "Multiple disks found, please select one:")
+	for i, d := range disks {
+		fmt.Fprintf(os.Stderr, "  [%d] %s\t%s\t%s\n", i+1, d.Target, d.Source, diskSize(d.Source))
+	}
+	fmt.Fprint(os.Stderr, "Enter selection: ")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return "", "", fmt.Errorf("no selection made")
+	}
+
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(disks) {
+		return "", "", fmt.Errorf("invalid selection %q", scanner.Text())
+	}
+	return disks[choice-1].Source, disks[choice-1].Target, nil
+}
+
+func diskSize(path string) string {
+	size, err := diskimage.Size(path)
+	if err != nil {
+		return "unknown size"
+	}
+	return size
+}
+
+func isInteractive() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ApplyDisk validates that imagePath belongs to myVM and is safe to resize,
+// then expands it to size.
+func ApplyDisk(myVM *vm.VM, vmName, imagePath, device string, partition int, size string) error {
+	isRunning, err := myVM.IsRunning()
+	if err != nil {
+		return fmt.Errorf("failed to check VM status: %w", err)
+	}
+	if isRunning {
+		return fmt.Errorf("VM '%s' is currently running. Please stop the VM before making changes", vmName)
+	}
+
+	if size == "" {
+		return fmt.Errorf("please specify the new size using the --size option")
+	}
+
+	belongsToVM, err := myVM.VerifyDiskBelongsToVM(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to verify disk ownership: %w", err)
+	}
+	if !belongsToVM {
+		return fmt.Errorf("the specified disk does not belong to VM '%s'", vmName)
+	}
+
+	if err := disk.ResizeAndExpandDisk(imagePath, device, partition, size); err != nil {
+		return fmt.Errorf("failed to resize and expand disk: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+// Package snapshot takes virsh checkpoints around mutating operations and
+// rolls back automatically if the operation fails.
+package snapshot
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Create takes a checkpoint of vmName named name. When external is true it
+// takes a disk-only external snapshot of device instead of an internal one.
+func Create(vmName, name string, external bool, device string) error {
+	args := []string{"snapshot-create-as", vmName, name}
+	if external {
+		diskspec := device
+		if diskspec != "" {
+			diskspec += ",snapshot=external"
+		}
+		args = append(args, "--disk-only", "--diskspec", diskspec)
+	}
+
+	out, err := exec.Command("virsh", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh snapshot-create-as failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// List returns the names of every snapshot of vmName.
+func List(vmName string) ([]string, error) {
+	out, err := exec.Command("virsh", "snapshot-list", vmName, "--name").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("virsh snapshot-list failed: %w: %s", err, out)
+	}
+
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+// Revert rolls vmName back to the snapshot named name.
+func Revert(vmName, name string) error {
+	out, err := exec.Command("virsh", "snapshot-revert", vmName, name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh snapshot-revert failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// Delete removes the snapshot named name from vmName.
+func Delete(vmName, name string) error {
+	out, err := exec.Command("virsh", "snapshot-delete", vmName, name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("virsh snapshot-delete failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// Guard takes a checkpoint of vmName, runs fn, and reverts to the checkpoint
+// if fn fails. virsh snapshot-revert cannot roll back an external (disk-only)
+// snapshot, so when external is true a failure is reported without attempting
+// a revert instead of calling an operation that is certain to fail itself.
+func Guard(vmName string, external bool, device string, fn func() error) error {
+	name := fmt.Sprintf("kvm-vm-tune-%d", time.Now().Unix())
+
+	if err := Create(vmName, name, external, device); err != nil {
+		return fmt.Errorf("failed to create safety snapshot: %w", err)
+	}
+
+	if err := fn(); err != nil {
+		if external {
+			return fmt.Errorf("operation failed: %w (automatic rollback is not supported for external snapshots; revert manually using the overlay created for snapshot %q, e.g. via virsh blockcommit)", err, name)
+		}
+
+		if revertErr := Revert(vmName, name); revertErr != nil {
+			return fmt.Errorf("operation failed (%v), and automatic rollback to snapshot %q also failed: %w", err, name, revertErr)
+		}
+		return fmt.Errorf("operation failed and was rolled back to snapshot %q: %w", name, err)
+	}
+
+	return nil
+}
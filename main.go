@@ -1,16 +1,25 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/spf13/cobra"
-	"github.com/zinrai/libvirtwrap-go/pkg/disk"
 	"github.com/zinrai/libvirtwrap-go/pkg/vm"
-	"github.com/zinrai/libvirtwrap-go/pkg/virsh"
+
+	"github.com/zinrai/kvm-vm-tune/internal/diskimage"
+	"github.com/zinrai/kvm-vm-tune/internal/live"
+	"github.com/zinrai/kvm-vm-tune/internal/resource"
+	"github.com/zinrai/kvm-vm-tune/internal/snapshot"
 )
 
+// defaultImagePoolDir is where `disk create` places new disk images, matching
+// libvirt's default storage pool location.
+const defaultImagePoolDir = "/var/lib/libvirt/images"
+
 var rootCmd = &cobra.Command{
 	Use:   "kvm-vm-tune",
 	Short: "KVM VM Resource Management CLI Tool",
@@ -38,22 +47,142 @@ var diskCmd = &cobra.Command{
 	Run:   runDiskCommand,
 }
 
+var diskCreateCmd = &cobra.Command{
+	Use:   "create <vm_name>",
+	Short: "Create a new disk image and attach it to a VM",
+	Args:  cobra.ExactArgs(1),
+	Run:   runDiskCreateCommand,
+}
+
+var diskDetachCmd = &cobra.Command{
+	Use:   "detach <vm_name>",
+	Short: "Detach a disk from a VM",
+	Args:  cobra.ExactArgs(1),
+	Run:   runDiskDetachCommand,
+}
+
+var diskLsCmd = &cobra.Command{
+	Use:   "ls <vm_name>",
+	Short: "List all disks attached to a VM",
+	Args:  cobra.ExactArgs(1),
+	Run:   runDiskLsCommand,
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage VM snapshots",
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create <vm_name> <snapshot_name>",
+	Short: "Create a snapshot of a VM",
+	Args:  cobra.ExactArgs(2),
+	Run:   runSnapshotCreateCommand,
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list <vm_name>",
+	Short: "List snapshots of a VM",
+	Args:  cobra.ExactArgs(1),
+	Run:   runSnapshotListCommand,
+}
+
+var snapshotRevertCmd = &cobra.Command{
+	Use:   "revert <vm_name> <snapshot_name>",
+	Short: "Revert a VM to a snapshot",
+	Args:  cobra.ExactArgs(2),
+	Run:   runSnapshotRevertCommand,
+}
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete <vm_name> <snapshot_name>",
+	Short: "Delete a VM snapshot",
+	Args:  cobra.ExactArgs(2),
+	Run:   runSnapshotDeleteCommand,
+}
+
+var setCmd = &cobra.Command{
+	Use:   "set <vm_name>",
+	Short: "Apply one or more resource changes to a VM in a single invocation",
+	Long: `Apply one or more resource changes to a VM in a single invocation.
+
+Unlike cpu/memory/disk, set keeps applying the remaining changes even if one
+of them fails, then reports every failure together at the end.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runSetCommand,
+}
+
+var (
+	imagePath   string
+	diskTarget  string
+	device      string
+	partition   int
+	size        string
+	dryRun      bool
+	liveMode    bool
+	guestResize bool
+	guestDevice string
+
+	snapshotFlag     bool
+	snapshotExternal bool
+)
+
+var snapshotCreateDevice string
+
+var (
+	setCPUs       int
+	setMemory     string
+	setDiskSize   string
+	setDiskDevice string
+)
+
 var (
-	imagePath string
-	device    string
-	partition int
-	size      string
-	dryRun    bool
+	createSize        string
+	createFormat      string
+	createBackingFile string
+	createTarget      string
+	detachTarget      string
 )
 
 func init() {
-	rootCmd.AddCommand(cpuCmd, memoryCmd, diskCmd)
+	rootCmd.AddCommand(cpuCmd, memoryCmd, diskCmd, setCmd, snapshotCmd)
 
 	diskCmd.Flags().StringVar(&imagePath, "image", "", "Path to the virtual machine image file")
+	diskCmd.Flags().StringVar(&diskTarget, "target", "", "Target device to resize when the VM has multiple disks (e.g., vdb)")
 	diskCmd.Flags().StringVar(&device, "device", "vda", "Disk device (e.g., vda, sda)")
 	diskCmd.Flags().IntVar(&partition, "partition", 1, "Partition number to expand")
 	diskCmd.Flags().StringVar(&size, "size", "", "New size for the disk (e.g., 40G)")
 	diskCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the command without executing it")
+	diskCmd.Flags().BoolVar(&liveMode, "live", false, "Resize the disk online instead of requiring the VM to be stopped")
+	diskCmd.Flags().BoolVar(&guestResize, "guest-resize", false, "After a --live resize, also grow the partition and filesystem inside the guest")
+	diskCmd.Flags().StringVar(&guestDevice, "guest-device", "", "Guest-visible device path for --guest-resize (e.g., /dev/vda); defaults to /dev/<device>")
+	diskCmd.Flags().BoolVar(&snapshotFlag, "snapshot", false, "Take a safety snapshot before resizing and roll back automatically on failure")
+	diskCmd.Flags().BoolVar(&snapshotExternal, "snapshot-external", false, "Use an external (disk-only) snapshot; required for --snapshot on raw disks. Automatic rollback on failure is not supported for external snapshots.")
+
+	cpuCmd.Flags().BoolVar(&liveMode, "live", false, "Hot-plug the vCPU count into the running VM instead of requiring it to be stopped")
+	cpuCmd.Flags().BoolVar(&snapshotFlag, "snapshot", false, "Take a safety snapshot before changing CPU count and roll back automatically on failure")
+
+	memoryCmd.Flags().BoolVar(&liveMode, "live", false, "Hot-plug the memory size into the running VM instead of requiring it to be stopped")
+	memoryCmd.Flags().BoolVar(&snapshotFlag, "snapshot", false, "Take a safety snapshot before changing memory size and roll back automatically on failure")
+
+	diskCmd.AddCommand(diskCreateCmd, diskDetachCmd, diskLsCmd)
+
+	diskCreateCmd.Flags().StringVar(&createSize, "size", "", "Size for the new disk image (e.g., 20G)")
+	diskCreateCmd.Flags().StringVar(&createFormat, "format", "qcow2", "Disk image format (qcow2 or raw)")
+	diskCreateCmd.Flags().StringVar(&createBackingFile, "backing-file", "", "Backing file for a copy-on-write image")
+	diskCreateCmd.Flags().StringVar(&createTarget, "target", "", "Target device name for the new disk (e.g., vdb)")
+
+	diskDetachCmd.Flags().StringVar(&detachTarget, "target", "", "Target device name to detach (e.g., vdb)")
+
+	snapshotCmd.AddCommand(snapshotCreateCmd, snapshotListCmd, snapshotRevertCmd, snapshotDeleteCmd)
+
+	snapshotCreateCmd.Flags().BoolVar(&snapshotExternal, "external", false, "Create an external (disk-only) snapshot instead of an internal one")
+	snapshotCreateCmd.Flags().StringVar(&snapshotCreateDevice, "device", "vda", "Disk device to use for the diskspec when --external is set")
+
+	setCmd.Flags().IntVar(&setCPUs, "cpus", 0, "New vCPU count for the VM")
+	setCmd.Flags().StringVar(&setMemory, "memory", "", "New memory size for the VM (e.g., 4G)")
+	setCmd.Flags().StringVar(&setDiskSize, "disk-size", "", "New size for the disk (e.g., 40G)")
+	setCmd.Flags().StringVar(&setDiskDevice, "disk-device", "vda", "Disk device to resize (e.g., vda, sda)")
 }
 
 func main() {
@@ -78,8 +207,31 @@ func runCPUCommand(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if err := myVM.SetCPUCount(cpuCount); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to change CPU count: %v\n", err)
+	if liveMode && snapshotFlag {
+		fmt.Fprintf(os.Stderr, "--snapshot is not supported together with --live; a live change is not covered by a checkpoint\n")
+		os.Exit(1)
+	}
+
+	if liveMode {
+		if err := live.SetVCPUs(vmName, cpuCount); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("CPU count changed to %d for VM '%s' (live).\n", cpuCount, vmName)
+		return
+	}
+
+	apply := func() error { return resource.ApplyCPU(myVM, cpuCount) }
+	if snapshotFlag {
+		apply = func() error {
+			return snapshot.Guard(vmName, snapshotExternal, "", func() error {
+				return resource.ApplyCPU(myVM, cpuCount)
+			})
+		}
+	}
+
+	if err := apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 	fmt.Printf("CPU count changed to %d for VM '%s'.\n", cpuCount, vmName)
@@ -96,8 +248,36 @@ func runMemoryCommand(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if err := myVM.SetMemorySize(memorySize); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to change memory size: %v\n", err)
+	if liveMode && snapshotFlag {
+		fmt.Fprintf(os.Stderr, "--snapshot is not supported together with --live; a live change is not covered by a checkpoint\n")
+		os.Exit(1)
+	}
+
+	if liveMode {
+		memoryKiB, err := live.ParseMemorySize(memorySize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if err := live.SetMemory(vmName, memoryKiB); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Memory size changed to %s for VM '%s' (live).\n", memorySize, vmName)
+		return
+	}
+
+	apply := func() error { return resource.ApplyMemory(myVM, memorySize) }
+	if snapshotFlag {
+		apply = func() error {
+			return snapshot.Guard(vmName, snapshotExternal, "", func() error {
+				return resource.ApplyMemory(myVM, memorySize)
+			})
+		}
+	}
+
+	if err := apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 	fmt.Printf("Memory size changed to %s for VM '%s'.\n", memorySize, vmName)
@@ -108,54 +288,255 @@ func runDiskCommand(cmd *cobra.Command, args []string) {
 
 	myVM := vm.New(vmName)
 
-	if imagePath == "" {
-		disks, err := virsh.GetVMDiskPaths(vmName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to get disk information for VM '%s': %v\n", vmName, err)
+	resolvedPath, resolvedTarget, err := resource.ResolveDiskPath(vmName, imagePath, diskTarget)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	imagePath = resolvedPath
+	if resolvedTarget != "" {
+		device = resolvedTarget
+	}
+
+	fmt.Printf("Selected disk: %s (device: %s)\n", imagePath, device)
+
+	if dryRun {
+		fmt.Printf("Would resize disk %s to %s for VM '%s'\n", imagePath, size, vmName)
+		return
+	}
+
+	if liveMode && snapshotFlag {
+		fmt.Fprintf(os.Stderr, "--snapshot is not supported together with --live; a live resize is not covered by a checkpoint\n")
+		os.Exit(1)
+	}
+
+	isRunning, err := myVM.IsRunning()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check VM status: %v\n", err)
+		os.Exit(1)
+	}
+
+	if isRunning {
+		if !liveMode {
+			fmt.Fprintf(os.Stderr, "VM '%s' is currently running. Please stop the VM before making changes, or pass --live to resize online.\n", vmName)
+			os.Exit(1)
+		}
+		if size == "" {
+			fmt.Fprintf(os.Stderr, "Please specify the new size using the --size option\n")
 			os.Exit(1)
 		}
-		if len(disks) == 0 {
-			fmt.Fprintf(os.Stderr, "No disks found for VM '%s'\n", vmName)
+
+		if err := live.ResizeDisk(vmName, device, size); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
-		imagePath = disks[0]
+		fmt.Printf("Disk %s resized to %s for VM '%s' (live).\n", imagePath, size, vmName)
+
+		if guestResize {
+			resolvedGuestDevice := guestDevice
+			if resolvedGuestDevice == "" {
+				resolvedGuestDevice = "/dev/" + device
+			}
+			if err := live.GrowGuestFilesystem(vmName, resolvedGuestDevice, partition); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Guest partition and filesystem grown for VM '%s'.\n", vmName)
+		}
+		return
 	}
 
-	fmt.Printf("Selected disk: %s (device: %s)\n", imagePath, device)
+	apply := func() error { return resource.ApplyDisk(myVM, vmName, imagePath, device, partition, size) }
+	if snapshotFlag {
+		if !snapshotExternal {
+			format, err := diskimage.Format(imagePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			if format == "raw" {
+				fmt.Fprintf(os.Stderr, "--snapshot is not supported for raw disks since qemu-img resize is not reversible on them; pass --snapshot-external to take an external checkpoint instead\n")
+				os.Exit(1)
+			}
+		}
+		apply = func() error {
+			return snapshot.Guard(vmName, snapshotExternal, device, func() error {
+				return resource.ApplyDisk(myVM, vmName, imagePath, device, partition, size)
+			})
+		}
+	}
+
+	if err := apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Disk expansion completed successfully for VM '%s'.\n", vmName)
+}
+
+func runSetCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+
+	myVM := vm.New(vmName)
 
+	var errs []error
+
+	if cmd.Flags().Changed("cpus") {
+		if err := resource.ApplyCPU(myVM, setCPUs); err != nil {
+			errs = append(errs, err)
+		} else {
+			fmt.Printf("CPU count changed to %d for VM '%s'.\n", setCPUs, vmName)
+		}
+	}
+
+	if setMemory != "" {
+		if err := resource.ApplyMemory(myVM, setMemory); err != nil {
+			errs = append(errs, err)
+		} else {
+			fmt.Printf("Memory size changed to %s for VM '%s'.\n", setMemory, vmName)
+		}
+	}
+
+	if setDiskSize != "" {
+		resolvedPath, resolvedTarget, err := resource.ResolveDiskPath(vmName, imagePath, setDiskDevice)
+		if err != nil {
+			errs = append(errs, err)
+		} else if err := resource.ApplyDisk(myVM, vmName, resolvedPath, resolvedTarget, partition, setDiskSize); err != nil {
+			errs = append(errs, err)
+		} else {
+			fmt.Printf("Disk %s resized to %s for VM '%s'.\n", resolvedPath, setDiskSize, vmName)
+		}
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "Failed to apply one or more changes: %v\n", errors.Join(errs...))
+		os.Exit(1)
+	}
+}
+
+func runDiskCreateCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+
+	if createSize == "" {
+		fmt.Fprintf(os.Stderr, "Please specify the disk size using the --size option\n")
+		os.Exit(1)
+	}
+	if createTarget == "" {
+		fmt.Fprintf(os.Stderr, "Please specify the target device using the --target option\n")
+		os.Exit(1)
+	}
+	if createFormat != "qcow2" && createFormat != "raw" {
+		fmt.Fprintf(os.Stderr, "Unsupported disk format %q: must be qcow2 or raw\n", createFormat)
+		os.Exit(1)
+	}
+
+	newImagePath := filepath.Join(defaultImagePoolDir, fmt.Sprintf("%s-%s.%s", vmName, createTarget, createFormat))
+
+	if err := diskimage.Create(newImagePath, createFormat, createSize, createBackingFile); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	myVM := vm.New(vmName)
 	isRunning, err := myVM.IsRunning()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to check VM status: %v\n", err)
 		os.Exit(1)
 	}
-	if isRunning {
-		fmt.Fprintf(os.Stderr, "VM '%s' is currently running. Please stop the VM before making changes.\n", vmName)
+
+	if err := diskimage.Attach(vmName, createTarget, newImagePath, createFormat, isRunning); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+	fmt.Printf("Disk %s (%s, %s) created and attached to VM '%s' as %s.\n", newImagePath, createSize, createFormat, vmName, createTarget)
+}
 
-	if size == "" {
-		fmt.Fprintf(os.Stderr, "Please specify the new size using the --size option\n")
+func runDiskDetachCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+
+	if detachTarget == "" {
+		fmt.Fprintf(os.Stderr, "Please specify the target device using the --target option\n")
 		os.Exit(1)
 	}
 
-	if dryRun {
-		fmt.Printf("Would resize disk %s to %s for VM '%s'\n", imagePath, size, vmName)
+	myVM := vm.New(vmName)
+	isRunning, err := myVM.IsRunning()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check VM status: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := diskimage.Detach(vmName, detachTarget, isRunning); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Disk %s detached from VM '%s'.\n", detachTarget, vmName)
+}
+
+func runDiskLsCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+
+	disks, err := diskimage.List(vmName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if len(disks) == 0 {
+		fmt.Printf("No disks found for VM '%s'\n", vmName)
 		return
 	}
 
-	belongsToVM, err := myVM.VerifyDiskBelongsToVM(imagePath)
+	for _, d := range disks {
+		fmt.Printf("%s\t%s\n", d.Target, d.Source)
+	}
+}
+
+func runSnapshotCreateCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+	snapName := args[1]
+
+	if err := snapshot.Create(vmName, snapName, snapshotExternal, snapshotCreateDevice); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot '%s' created for VM '%s'.\n", snapName, vmName)
+}
+
+func runSnapshotListCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+
+	names, err := snapshot.List(vmName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to verify disk ownership: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
-	if !belongsToVM {
-		fmt.Fprintf(os.Stderr, "The specified disk does not belong to VM '%s'\n", vmName)
+	if len(names) == 0 {
+		fmt.Printf("No snapshots found for VM '%s'\n", vmName)
+		return
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+func runSnapshotRevertCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+	snapName := args[1]
+
+	if err := snapshot.Revert(vmName, snapName); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+	fmt.Printf("VM '%s' reverted to snapshot '%s'.\n", vmName, snapName)
+}
 
-	if err := disk.ResizeAndExpandDisk(imagePath, device, partition, size); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to resize and expand disk: %v\n", err)
+func runSnapshotDeleteCommand(cmd *cobra.Command, args []string) {
+	vmName := args[0]
+	snapName := args[1]
+
+	if err := snapshot.Delete(vmName, snapName); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Disk expansion completed successfully for VM '%s'.\n", vmName)
+	fmt.Printf("Snapshot '%s' deleted for VM '%s'.\n", snapName, vmName)
 }